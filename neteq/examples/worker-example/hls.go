@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// hlsSegmentWindow bounds how many recently-fetched segment URIs are
+	// remembered for dedup, so re-polling a live playlist doesn't redownload
+	// segments that already scrolled out of its window.
+	hlsSegmentWindow = 64
+
+	// hlsMinBufferedSegments is how many segments must be queued before the
+	// fetcher starts writing them out, to absorb jitter in segment
+	// availability before playback starts.
+	hlsMinBufferedSegments = 2
+
+	// hlsDefaultTargetDuration is the re-poll interval used until the
+	// playlist reports its own #EXT-X-TARGETDURATION.
+	hlsDefaultTargetDuration = 6 * time.Second
+)
+
+// isM3U8 reports whether rawURL points at an HLS media playlist, based on
+// its path's file extension.
+func isM3U8(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return strings.HasSuffix(strings.ToLower(rawURL), ".m3u8")
+	}
+	return strings.EqualFold(pathExt(u.Path), ".m3u8")
+}
+
+func pathExt(p string) string {
+	if i := strings.LastIndex(p, "/"); i >= 0 {
+		p = p[i+1:]
+	}
+	if i := strings.LastIndex(p, "."); i >= 0 {
+		return p[i:]
+	}
+	return ""
+}
+
+// openHLSSource drives playlistURL as a continuous HLS source and returns a
+// reader that streams the concatenated, in-order segment bytes as they're
+// fetched, so the rest of the pipeline (convertToOgg onward) sees a single
+// ordinary byte stream. Cancelling ctx stops the fetch loop and closes the
+// reader.
+func openHLSSource(ctx context.Context, playlistURL string) io.ReadCloser {
+	pr, pw := io.Pipe()
+	client := &http.Client{
+		Timeout: 0, // rely on context cancellation
+		Transport: &http.Transport{
+			DialContext: (&net.Dialer{Timeout: 10 * time.Second}).DialContext,
+		},
+	}
+	go func() {
+		err := fetchHLS(ctx, client, playlistURL, pw)
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// fetchHLS polls playlistURL, downloads newly-listed segments in order, and
+// writes their bytes into w. It dedupes segment URIs against a sliding
+// window so a re-polled live playlist doesn't redownload old segments,
+// waits for hlsMinBufferedSegments before writing anything so a burst of
+// late segments doesn't immediately underrun, and re-polls at an interval
+// derived from #EXT-X-TARGETDURATION. It returns when the playlist reports
+// #EXT-X-ENDLIST (VOD) or when ctx is cancelled.
+func fetchHLS(ctx context.Context, client *http.Client, playlistURL string, w io.Writer) error {
+	seen := newSeenWindow(hlsSegmentWindow)
+	var pending []string
+	started := false
+	targetDuration := hlsDefaultTargetDuration
+
+	for {
+		segments, ended, td, err := fetchPlaylist(ctx, client, playlistURL)
+		if err != nil {
+			return fmt.Errorf("error fetching HLS playlist: %w", err)
+		}
+		if td > 0 {
+			targetDuration = td
+		}
+
+		for _, seg := range segments {
+			if seen.contains(seg) {
+				continue
+			}
+			seen.add(seg)
+			pending = append(pending, seg)
+		}
+
+		if !started && len(pending) < hlsMinBufferedSegments && !ended {
+			if err := sleepOrDone(ctx, targetDuration/2); err != nil {
+				return err
+			}
+			continue
+		}
+
+		started = true
+		for len(pending) > 0 {
+			seg := pending[0]
+			pending = pending[1:]
+			if err := fetchSegment(ctx, client, seg, w); err != nil {
+				return fmt.Errorf("error fetching HLS segment %s: %w", seg, err)
+			}
+		}
+
+		if ended {
+			return nil
+		}
+
+		if err := sleepOrDone(ctx, targetDuration); err != nil {
+			return err
+		}
+	}
+}
+
+func sleepOrDone(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
+
+// fetchPlaylist fetches and parses an HLS media playlist, resolving segment
+// URIs relative to playlistURL.
+func fetchPlaylist(ctx context.Context, client *http.Client, playlistURL string) (segments []string, ended bool, targetDuration time.Duration, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, playlistURL, nil)
+	if err != nil {
+		return nil, false, 0, fmt.Errorf("bad playlist url: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, false, 0, fmt.Errorf("failed to fetch playlist: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, false, 0, fmt.Errorf("upstream returned %d", resp.StatusCode)
+	}
+
+	base, err := url.Parse(playlistURL)
+	if err != nil {
+		return nil, false, 0, fmt.Errorf("bad playlist url: %w", err)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+			continue
+		case line == "#EXT-X-ENDLIST":
+			ended = true
+		case strings.HasPrefix(line, "#EXT-X-TARGETDURATION:"):
+			if secs, convErr := strconv.Atoi(strings.TrimPrefix(line, "#EXT-X-TARGETDURATION:")); convErr == nil {
+				targetDuration = time.Duration(secs) * time.Second
+			}
+		case strings.HasPrefix(line, "#"):
+			// ignore other tags (EXT-X-VERSION, EXTINF, EXT-X-MEDIA-SEQUENCE, ...)
+		default:
+			segURL, resolveErr := base.Parse(line)
+			if resolveErr != nil {
+				log.Printf("skipping unparseable HLS segment uri %q: %v", line, resolveErr)
+				continue
+			}
+			segments = append(segments, segURL.String())
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, false, 0, fmt.Errorf("error reading playlist: %w", err)
+	}
+
+	return segments, ended, targetDuration, nil
+}
+
+func fetchSegment(ctx context.Context, client *http.Client, segURL string, w io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, segURL, nil)
+	if err != nil {
+		return fmt.Errorf("bad segment url: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch segment: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("upstream returned %d", resp.StatusCode)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// seenWindow is a fixed-capacity sliding window of recently seen strings,
+// used to dedupe HLS segment URIs across playlist re-polls without growing
+// unbounded over a long-running live stream.
+type seenWindow struct {
+	capacity int
+	order    []string
+	set      map[string]struct{}
+}
+
+func newSeenWindow(capacity int) *seenWindow {
+	return &seenWindow{capacity: capacity, set: make(map[string]struct{}, capacity)}
+}
+
+func (s *seenWindow) contains(v string) bool {
+	_, ok := s.set[v]
+	return ok
+}
+
+func (s *seenWindow) add(v string) {
+	if s.contains(v) {
+		return
+	}
+	s.order = append(s.order, v)
+	s.set[v] = struct{}{}
+	if len(s.order) > s.capacity {
+		oldest := s.order[0]
+		s.order = s.order[1:]
+		delete(s.set, oldest)
+	}
+}
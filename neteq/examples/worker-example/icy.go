@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// icyMetadataBlockUnit is the factor metadata block lengths are expressed
+// in: the single byte read after metaint bytes of audio is the block
+// length divided by 16.
+const icyMetadataBlockUnit = 16
+
+// metadataPollDefaultInterval is used between metadata_url polls when the
+// server doesn't provide its own timeoutMs.
+const metadataPollDefaultInterval = 5 * time.Second
+
+// Metadata is forwarded to the client as a "metadata" command whenever the
+// current track title changes, whether learned from inline ICY metadata or
+// an out-of-band metadata_url poll.
+type Metadata struct {
+	Title string `json:"title"`
+}
+
+// MetadataPollResponse is the expected JSON shape of a metadata_url
+// endpoint: the current title plus how long to wait before polling again,
+// similar in spirit to the BBC's nhppolling long-poll responses.
+type MetadataPollResponse struct {
+	Title     string `json:"title"`
+	TimeoutMS int    `json:"timeoutMs"`
+}
+
+// icyReader strips inline ICY/Shoutcast metadata blocks out of an audio
+// stream (the Icy-MetaData/icy-metaint protocol), forwarding only audio
+// bytes to Read and reporting each StreamTitle change via onTitle.
+type icyReader struct {
+	src       io.Reader
+	metaint   int
+	untilMeta int
+	onTitle   func(string)
+	lastTitle string
+}
+
+func newICYReader(src io.Reader, metaint int, onTitle func(string)) *icyReader {
+	return &icyReader{src: src, metaint: metaint, untilMeta: metaint, onTitle: onTitle}
+}
+
+func (r *icyReader) Read(p []byte) (int, error) {
+	if r.untilMeta == 0 {
+		if err := r.consumeMetadata(); err != nil {
+			return 0, err
+		}
+		r.untilMeta = r.metaint
+	}
+
+	if len(p) > r.untilMeta {
+		p = p[:r.untilMeta]
+	}
+	n, err := r.src.Read(p)
+	r.untilMeta -= n
+	return n, err
+}
+
+func (r *icyReader) consumeMetadata() error {
+	lenByte := make([]byte, 1)
+	if _, err := io.ReadFull(r.src, lenByte); err != nil {
+		return err
+	}
+	blockLen := int(lenByte[0]) * icyMetadataBlockUnit
+	if blockLen == 0 {
+		return nil
+	}
+
+	block := make([]byte, blockLen)
+	if _, err := io.ReadFull(r.src, block); err != nil {
+		return err
+	}
+
+	if title, ok := parseStreamTitle(block); ok && title != r.lastTitle {
+		r.lastTitle = title
+		if r.onTitle != nil {
+			r.onTitle(title)
+		}
+	}
+	return nil
+}
+
+// parseStreamTitle extracts StreamTitle='...' from a raw ICY metadata
+// block, which is null-padded and semicolon-terminated.
+func parseStreamTitle(block []byte) (string, bool) {
+	text := strings.TrimRight(string(block), "\x00")
+	const key = "StreamTitle='"
+	idx := strings.Index(text, key)
+	if idx < 0 {
+		return "", false
+	}
+	rest := text[idx+len(key):]
+	end := strings.Index(rest, "';")
+	if end < 0 {
+		return "", false
+	}
+	return rest[:end], true
+}
+
+// pollMetadataURL polls an out-of-band JSON metadata endpoint, calling
+// onTitle whenever the reported title changes, and waits the
+// server-provided timeoutMs (or metadataPollDefaultInterval) between polls.
+// It returns when ctx is cancelled.
+func pollMetadataURL(ctx context.Context, metadataURL string, onTitle func(string)) {
+	client := &http.Client{}
+	lastTitle := ""
+
+	for {
+		resp, err := pollMetadataOnce(ctx, client, metadataURL)
+		interval := metadataPollDefaultInterval
+		if err != nil {
+			log.Printf("error polling metadata_url: %v", err)
+		} else {
+			if resp.Title != "" && resp.Title != lastTitle {
+				lastTitle = resp.Title
+				onTitle(resp.Title)
+			}
+			if resp.TimeoutMS > 0 {
+				interval = time.Duration(resp.TimeoutMS) * time.Millisecond
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func pollMetadataOnce(ctx context.Context, client *http.Client, metadataURL string) (*MetadataPollResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadataURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("bad metadata url: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch metadata: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("upstream returned %d", resp.StatusCode)
+	}
+
+	out := &MetadataPollResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return nil, fmt.Errorf("error parsing metadata response: %w", err)
+	}
+	return out, nil
+}
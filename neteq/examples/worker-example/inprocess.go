@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"time"
+
+	"github.com/hajimehoshi/go-mp3"
+	"github.com/hraban/opus"
+)
+
+const (
+	opusSampleRate   = 48000
+	opusChannels     = 1
+	opusFrameSamples = opusSampleRate / 50 // 20ms at 48kHz
+)
+
+// SpeedData is the payload for a "speed" command: 0.5x-2.0x, applied by
+// resampling the decoded PCM before re-encoding to Opus.
+type SpeedData struct {
+	Speed float64 `json:"speed"`
+}
+
+// SeekData is the payload for a "seek" command: an absolute position, in
+// seconds, into the currently playing in-process track.
+type SeekData struct {
+	Seconds float64 `json:"seconds"`
+}
+
+// decodable reports whether format has an in-process decoder, letting
+// processAudioUrl skip ffmpeg entirely. Anything else (exotic formats,
+// FormatOpusOgg's passthrough) still goes through convertToOgg.
+func decodable(format AudioFormat) bool {
+	switch format {
+	case FormatMP3, FormatPCMS16LE:
+		return true
+	default:
+		return false
+	}
+}
+
+// decodeToPCM fully decodes audio into mono int16 PCM at opusSampleRate.
+func decodeToPCM(audio io.Reader, format AudioFormat) ([]int16, error) {
+	switch format {
+	case FormatMP3:
+		return decodeMP3(audio)
+	case FormatPCMS16LE:
+		return decodeRawPCM(audio)
+	default:
+		return nil, fmt.Errorf("no in-process decoder for format %q", format)
+	}
+}
+
+func decodeMP3(audio io.Reader) ([]int16, error) {
+	dec, err := mp3.NewDecoder(audio)
+	if err != nil {
+		return nil, fmt.Errorf("error opening mp3 decoder: %w", err)
+	}
+
+	// go-mp3 always decodes to interleaved stereo 16-bit LE; downmix to mono
+	// as we read.
+	stereo := make([]byte, 4*opusSampleRate)
+	var mono []int16
+	for {
+		n, err := dec.Read(stereo)
+		for i := 0; i+3 < n; i += 4 {
+			left := int16(uint16(stereo[i]) | uint16(stereo[i+1])<<8)
+			right := int16(uint16(stereo[i+2]) | uint16(stereo[i+3])<<8)
+			mono = append(mono, int16((int(left)+int(right))/2))
+		}
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("error decoding mp3: %w", err)
+		}
+	}
+
+	if rate := dec.SampleRate(); rate != opusSampleRate {
+		mono = resamplePCM(mono, rate, opusSampleRate)
+	}
+
+	return mono, nil
+}
+
+// decodeRawPCM reads headerless mono signed 16-bit little-endian PCM
+// already at opusSampleRate, the shape FormatPCMS16LE assumes for
+// ffmpeg-less deployments.
+func decodeRawPCM(audio io.Reader) ([]int16, error) {
+	raw, err := io.ReadAll(audio)
+	if err != nil {
+		return nil, fmt.Errorf("error reading raw pcm: %w", err)
+	}
+
+	samples := make([]int16, len(raw)/2)
+	for i := range samples {
+		samples[i] = int16(uint16(raw[2*i]) | uint16(raw[2*i+1])<<8)
+	}
+	return samples, nil
+}
+
+// resamplePCM linearly resamples in from fromRate to toRate. It's used both
+// to normalize a decoder's native rate to opusSampleRate and, via
+// applySpeed, to implement the "speed" command: resampling shifts tempo and
+// pitch together, the same tradeoff dischord's playbackSpeed makes.
+func resamplePCM(in []int16, fromRate, toRate int) []int16 {
+	if fromRate == toRate || len(in) == 0 {
+		return in
+	}
+
+	outLen := int(float64(len(in)) * float64(toRate) / float64(fromRate))
+	out := make([]int16, outLen)
+	for i := range out {
+		srcPos := float64(i) * float64(fromRate) / float64(toRate)
+		i0 := int(srcPos)
+		if i0 >= len(in)-1 {
+			out[i] = in[len(in)-1]
+			continue
+		}
+		frac := srcPos - float64(i0)
+		out[i] = int16(float64(in[i0])*(1-frac) + float64(in[i0+1])*frac)
+	}
+	return out
+}
+
+// applySpeed resamples samples (already at opusSampleRate) so that playing
+// the result back at opusSampleRate sounds speed times faster.
+func applySpeed(samples []int16, speed float64) []int16 {
+	if speed <= 0 {
+		speed = 1
+	}
+	return resamplePCM(samples, int(math.Round(opusSampleRate*speed)), opusSampleRate)
+}
+
+// encodeToRingBuffer Opus-encodes samples in 20ms frames directly into buf,
+// starting at sequence number 0, and marks buf done once samples is
+// exhausted or ctx is cancelled.
+func encodeToRingBuffer(ctx context.Context, samples []int16, buf *packetRingBuffer) error {
+	defer buf.markDone()
+
+	enc, err := opus.NewEncoder(opusSampleRate, opusChannels, opus.AppAudio)
+	if err != nil {
+		return fmt.Errorf("error creating opus encoder: %w", err)
+	}
+
+	data := make([]byte, 4000)
+	seq := 0
+	for start := 0; start < len(samples); start += opusFrameSamples {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		end := min(start+opusFrameSamples, len(samples))
+		pcm := samples[start:end]
+		if len(pcm) < opusFrameSamples {
+			padded := make([]int16, opusFrameSamples)
+			copy(padded, pcm)
+			pcm = padded
+		}
+
+		n, err := enc.Encode(pcm, data)
+		if err != nil {
+			return fmt.Errorf("error encoding opus frame: %w", err)
+		}
+
+		packet := make([]byte, n)
+		copy(packet, data[:n])
+		buf.write(seq, packet)
+		seq++
+	}
+
+	return nil
+}
+
+// inProcessPlayback tracks enough state about a track decoded through the
+// in-process pipeline to rebuild its Opus ring buffer from an arbitrary
+// position and speed on demand, without re-fetching or re-decoding the
+// source. This is what makes "speed" and "seek" cheap.
+type inProcessPlayback struct {
+	samples     []int16 // original mono PCM at opusSampleRate, i.e. speed=1
+	speed       float64
+	baseSeconds float64
+	startedAt   time.Time
+}
+
+// positionSeconds estimates how far into samples playback has reached.
+func (t *inProcessPlayback) positionSeconds() float64 {
+	return t.baseSeconds + time.Since(t.startedAt).Seconds()*t.speed
+}
+
+// rebuild re-encodes samples from the given position at the given speed
+// into a fresh ring buffer (the caller swaps it into opusBuf, which resets
+// sendOpusPackets' sequence counter the same way a "start" does), and
+// updates the tracked position/speed to match.
+func (t *inProcessPlayback) rebuild(ctx context.Context, seconds, speed float64) (*packetRingBuffer, error) {
+	if speed <= 0 {
+		speed = 1
+	}
+	speed = min(max(speed, 0.5), 2.0)
+
+	startSample := int(seconds * opusSampleRate)
+	switch {
+	case startSample < 0:
+		startSample = 0
+	case startSample > len(t.samples):
+		startSample = len(t.samples)
+	}
+
+	sped := applySpeed(t.samples[startSample:], speed)
+
+	buf := newPacketRingBuffer()
+	go func() {
+		if err := encodeToRingBuffer(ctx, sped, buf); err != nil && ctx.Err() == nil {
+			log.Printf("error encoding opus: %v", err)
+		}
+	}()
+
+	t.speed = speed
+	t.baseSeconds = seconds
+	t.startedAt = time.Now()
+
+	return buf, nil
+}
@@ -1,9 +1,9 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -14,6 +14,8 @@ import (
 	"os/exec"
 	"path"
 	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -27,9 +29,31 @@ type Command struct {
 }
 
 type StartData struct {
-	URL string `json:"url"`
+	URL    string      `json:"url"`
+	Format AudioFormat `json:"format,omitempty"`
+	// MetadataURL, if set, is polled out-of-band for "now playing" JSON
+	// metadata instead of (or in addition to) any inline ICY metadata.
+	MetadataURL string `json:"metadata_url,omitempty"`
 }
 
+// AudioFormat identifies the container/codec of a source so the pipeline can
+// skip ffmpeg when it isn't needed.
+type AudioFormat string
+
+const (
+	// FormatAuto lets detectFormat infer the format from Content-Type, the
+	// URL extension, and a probe of the leading header bytes.
+	FormatAuto AudioFormat = ""
+	// FormatOpusOgg is Opus-in-Ogg at 48kHz mono, i.e. already in the exact
+	// shape sendOpusPackets needs, so it's passed through unchanged.
+	FormatOpusOgg AudioFormat = "opus"
+	// FormatPCMS16LE is headerless signed 16-bit little-endian PCM, as used
+	// by clipper's rawAudio pipeline.
+	FormatPCMS16LE AudioFormat = "pcm_s16le"
+	// FormatMP3 is an MP3 bitstream.
+	FormatMP3 AudioFormat = "mp3"
+)
+
 type Config struct {
 	JitterMS   int     `json:"jitterMs"`
 	PacketLoss float64 `json:"packetLoss"`
@@ -39,14 +63,132 @@ type Pause struct {
 	PauseMS int64 `json:"pauseMs"`
 }
 
+// NowPlaying is sent to the client as a "now_playing" command when a track
+// resolved from a YouTube URL becomes the one playing, so the UI can display
+// what's playing.
+type NowPlaying struct {
+	Title    string  `json:"title"`
+	Duration float64 `json:"duration"`
+}
+
+// sendCommand writes a Command-shaped JSON text frame to the client. writeMu
+// must be the same mutex used to guard the connection's binary packet
+// writes, since gorilla/websocket doesn't allow concurrent writers.
+func sendCommand(conn *websocket.Conn, writeMu *sync.Mutex, cmdType string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("error marshaling %s command: %w", cmdType, err)
+	}
+	msg, err := json.Marshal(Command{Type: cmdType, Data: payload})
+	if err != nil {
+		return fmt.Errorf("error marshaling %s command: %w", cmdType, err)
+	}
+
+	writeMu.Lock()
+	defer writeMu.Unlock()
+	return conn.WriteMessage(websocket.TextMessage, msg)
+}
+
 var upgrader = websocket.Upgrader{} // use default options
 
-// saveOpusPackets reads the ogg file, and saves opus packets one by one into
-// a temp directory.
-func saveOpusPackets(_ context.Context, oggReader io.Reader) (string, error) {
+const (
+	// ringBufferCapacity bounds the packet ring buffer; at 20ms/packet this
+	// covers ~5 minutes of audio, matching typical streaming-bot ring sizes.
+	ringBufferCapacity = 15000
+
+	// jitterBufferPackets is how many packets must be buffered ahead of the
+	// playback cursor before sendOpusPackets starts emitting them.
+	jitterBufferPackets = 5
+
+	// underrunLogInterval rate-limits recordUnderrun's warning so a source
+	// that's merely lagging (the common case) doesn't spam the log once per
+	// 20ms tick.
+	underrunLogInterval = time.Second
+)
+
+// packetRingBuffer is a fixed-capacity circular buffer of Opus packets keyed
+// by sequence number (seq % capacity). It is written by the ogg parser
+// goroutine in saveOpusPackets (or, for in-process tracks, by
+// encodeToRingBuffer) and read by sendOpusPackets, replacing the
+// previous tempdir-of-files approach so neither side touches disk per frame.
+type packetRingBuffer struct {
+	mu                   sync.RWMutex
+	packets              [][]byte
+	written              atomic.Int64 // one past the highest sequence number written
+	underruns            atomic.Int64
+	lastUnderrunLogNanos atomic.Int64
+	done                 atomic.Bool // true once the source has finished producing packets
+}
+
+func newPacketRingBuffer() *packetRingBuffer {
+	return &packetRingBuffer{packets: make([][]byte, ringBufferCapacity)}
+}
+
+func (b *packetRingBuffer) write(seq int, packet []byte) {
+	b.mu.Lock()
+	b.packets[seq%ringBufferCapacity] = packet
+	b.mu.Unlock()
+	b.written.Store(int64(seq + 1))
+}
+
+// read returns the packet for seq, or ok=false if it hasn't been written yet
+// (or has already been overwritten by the ring wrapping around).
+func (b *packetRingBuffer) read(seq int) (packet []byte, ok bool) {
+	written := b.written.Load()
+	if int64(seq) >= written {
+		return nil, false
+	}
+	if written-int64(seq) > ringBufferCapacity {
+		// seq's slot has already been overwritten by the ring wrapping
+		// around; fail instead of returning the wrong, newer packet.
+		return nil, false
+	}
+	b.mu.RLock()
+	packet = b.packets[seq%ringBufferCapacity]
+	b.mu.RUnlock()
+	return packet, packet != nil
+}
+
+// available reports how many packets (by sequence number, starting at 0)
+// have been written so far.
+func (b *packetRingBuffer) available() int {
+	return int(b.written.Load())
+}
+
+// recordUnderrun increments the underrun counter and, at most once per
+// underrunLogInterval, logs it so operators can see the jitter buffer
+// starving without a line per dropped frame.
+func (b *packetRingBuffer) recordUnderrun() {
+	b.underruns.Add(1)
+
+	now := time.Now().UnixNano()
+	last := b.lastUnderrunLogNanos.Load()
+	if now-last >= int64(underrunLogInterval) && b.lastUnderrunLogNanos.CompareAndSwap(last, now) {
+		log.Printf("opus ring buffer underrun (count=%d)", b.underrunCount())
+	}
+}
+
+func (b *packetRingBuffer) underrunCount() int64 {
+	return b.underruns.Load()
+}
+
+// markDone records that the source will never write another packet, so
+// sendOpusPackets can tell a real end-of-track apart from the sender merely
+// catching up to a source that's still being fetched/decoded.
+func (b *packetRingBuffer) markDone() {
+	b.done.Store(true)
+}
+
+func (b *packetRingBuffer) isDone() bool {
+	return b.done.Load()
+}
+
+// saveOpusPackets reads the ogg stream and writes opus packets one by one
+// into an in-memory ring buffer as they arrive.
+func saveOpusPackets(_ context.Context, oggReader io.Reader) (*packetRingBuffer, error) {
 	reader, _, err := oggreader.NewWith(oggReader)
 	if err != nil {
-		return "", fmt.Errorf("error reading ogg header: %w", err)
+		return nil, fmt.Errorf("error reading ogg header: %w", err)
 	}
 
 	// ignore OpusTags tagsPage
@@ -55,15 +197,14 @@ func saveOpusPackets(_ context.Context, oggReader io.Reader) (string, error) {
 		err = fmt.Errorf("expected OpusTags packet, found something else")
 	}
 	if err != nil {
-		return "", fmt.Errorf("error reading ogg OpusTags: %w", err)
+		return nil, fmt.Errorf("error reading ogg OpusTags: %w", err)
 	}
 
-	dir, err := os.MkdirTemp("", "opus")
-	if err != nil {
-		return "", fmt.Errorf("error making tmp dir: %w", err)
-	}
+	buf := newPacketRingBuffer()
 
 	go func() {
+		defer buf.markDone()
+
 		seq := -1
 		for {
 			page, _, err := reader.ParseNextPage()
@@ -75,25 +216,23 @@ func saveOpusPackets(_ context.Context, oggReader io.Reader) (string, error) {
 				return
 			}
 
-			// if seq < 5 {
-			// 	log.Printf("wtf %#+v %#+v %s", h0, h, string(page[:min(len(page), 8)]))
-			// }
-
 			seq++
-			os.WriteFile(path.Join(dir, strconv.Itoa(seq)), page, 0600)
-
+			buf.write(seq, page)
 		}
 	}()
 
-	return dir, nil
+	return buf, nil
 }
 
-// readRemoteAudio reads an audio file from a given url
-func readRemoteAudio(ctx context.Context, url string) (io.ReadCloser, error) {
+// readRemoteAudio reads an audio file from a given url, returning the
+// upstream Content-Type alongside the body so callers can use it for format
+// detection.
+func readRemoteAudio(ctx context.Context, url string, onTitle func(string)) (io.ReadCloser, string, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return nil, fmt.Errorf("bad url: %w", err)
+		return nil, "", fmt.Errorf("bad url: %w", err)
 	}
+	req.Header.Set("Icy-MetaData", "1")
 	client := &http.Client{
 		Timeout: 0, // rely on context cancellation; we could set a global timeout if wanted
 		Transport: &http.Transport{
@@ -103,23 +242,80 @@ func readRemoteAudio(ctx context.Context, url string) (io.ReadCloser, error) {
 	}
 	srcResp, err := client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch source: %w", err)
+		return nil, "", fmt.Errorf("failed to fetch source: %w", err)
 	}
 	if srcResp.StatusCode < 200 || srcResp.StatusCode >= 300 {
 		body := make([]byte, 512)
 		n, _ := srcResp.Body.Read(body)
 		srcResp.Body.Close()
-		return nil, fmt.Errorf("upstream returned %d: %s", srcResp.StatusCode, string(body[:n]))
+		return nil, "", fmt.Errorf("upstream returned %d: %s", srcResp.StatusCode, string(body[:n]))
 	}
 
-	return srcResp.Body, nil
+	body := io.Reader(srcResp.Body)
+	if metaint, convErr := strconv.Atoi(srcResp.Header.Get("icy-metaint")); convErr == nil && metaint > 0 {
+		body = newICYReader(srcResp.Body, metaint, onTitle)
+	}
+
+	return &peekedReadCloser{Reader: body, Closer: srcResp.Body}, srcResp.Header.Get("Content-Type"), nil
 }
 
-// convertToOgg converts any audio format into ogg contained mono channel 48Khz opus
-func convertToOgg(ctx context.Context, audio io.ReadCloser) (io.ReadCloser, error) {
+// detectFormat resolves the input format: an explicit override always wins,
+// otherwise it falls back to the upstream Content-Type, then the URL's file
+// extension, and finally a probe of the leading header bytes.
+func detectFormat(override AudioFormat, contentType, url string, head []byte) AudioFormat {
+	if override != FormatAuto {
+		return override
+	}
+
+	switch {
+	case strings.Contains(contentType, "audio/ogg"), strings.Contains(contentType, "audio/opus"):
+		return FormatOpusOgg
+	case strings.Contains(contentType, "audio/mpeg"), strings.Contains(contentType, "audio/mp3"):
+		return FormatMP3
+	case strings.Contains(contentType, "audio/l16"), strings.Contains(contentType, "audio/pcm"):
+		return FormatPCMS16LE
+	}
+
+	switch strings.ToLower(path.Ext(url)) {
+	case ".ogg", ".opus":
+		return FormatOpusOgg
+	case ".mp3":
+		return FormatMP3
+	case ".pcm", ".raw":
+		return FormatPCMS16LE
+	}
+
+	switch {
+	case len(head) >= 4 && string(head[:4]) == "OggS":
+		return FormatOpusOgg
+	case len(head) >= 3 && string(head[:3]) == "ID3":
+		return FormatMP3
+	case len(head) >= 2 && head[0] == 0xFF && head[1]&0xE0 == 0xE0:
+		return FormatMP3
+	}
+
+	return FormatAuto
+}
+
+// convertToOgg converts audio into ogg contained mono channel 48kHz opus. If
+// format is already FormatOpusOgg the input is passed through unchanged,
+// since it's already in the shape sendOpusPackets needs.
+func convertToOgg(ctx context.Context, audio io.ReadCloser, format AudioFormat) (io.ReadCloser, error) {
+	if format == FormatOpusOgg {
+		return audio, nil
+	}
+
 	ffmpegArgs := []string{
 		"-hide_banner",
 		"-loglevel", "warning",
+	}
+	switch format {
+	case FormatPCMS16LE:
+		ffmpegArgs = append(ffmpegArgs, "-f", "s16le", "-ar", "48000", "-ac", "1")
+	case FormatMP3:
+		ffmpegArgs = append(ffmpegArgs, "-f", "mp3")
+	}
+	ffmpegArgs = append(ffmpegArgs,
 		"-i", "pipe:0", // input from stdin (we'll copy srcResp.Body into ffmpeg stdin)
 		"-vn",
 		"-ac", "1", // mono
@@ -129,7 +325,7 @@ func convertToOgg(ctx context.Context, audio io.ReadCloser) (io.ReadCloser, erro
 		"-page_duration", "20000", // one frame per page (20ms page)
 		"-f", "ogg", // output as ogg (so we can parse pages & packet boundaries)
 		"pipe:1", // stdout
-	}
+	)
 	cmd := exec.CommandContext(ctx, "ffmpeg", ffmpegArgs...)
 	ffIn, err := cmd.StdinPipe()
 	if err != nil {
@@ -165,38 +361,133 @@ func convertToOgg(ctx context.Context, audio io.ReadCloser) (io.ReadCloser, erro
 	return ffOut, nil
 }
 
-func processAudioUrl(ctx context.Context, url string) (string, error) {
-	audioReader, err := readRemoteAudio(ctx, url)
-	if err != nil {
-		return "", fmt.Errorf("error reading stream: %w", err)
+// peekedReadCloser replays bytes already consumed by a Peek while still
+// closing the original body on Close.
+type peekedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// processAudioUrl fetches and decodes url into a ring buffer of Opus
+// packets. The returned *inProcessPlayback is non-nil when format was
+// explicitly set to an in-process-decodable format (see decodable), which
+// is what lets "speed" and "seek" commands rebuild playback cheaply; for
+// anything auto-detected or shelled out to ffmpeg it's nil.
+func processAudioUrl(ctx context.Context, url string, format AudioFormat, onTitle func(string)) (*packetRingBuffer, *inProcessPlayback, error) {
+	var (
+		audioReader io.ReadCloser
+		contentType string
+		head        []byte
+	)
+
+	if isM3U8(url) {
+		// HLS sources are driven by a continuous segment fetcher rather than
+		// a single GET; convertToOgg/saveOpusPackets see an ordinary stream.
+		audioReader = openHLSSource(ctx, url)
+	} else {
+		body, ct, err := readRemoteAudio(ctx, url, onTitle)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading stream: %w", err)
+		}
+		contentType = ct
+
+		br := bufio.NewReaderSize(body, 512)
+		head, _ = br.Peek(512)
+		audioReader = &peekedReadCloser{Reader: br, Closer: body}
 	}
 
-	oggReader, err := convertToOgg(ctx, audioReader)
+	resolved := detectFormat(format, contentType, url, head)
+
+	// Only take the in-process path when the caller explicitly asked for a
+	// decodable format. decodeToPCM reads its source to EOF before any audio
+	// plays, which is fine for a known-finite file but would hang forever
+	// (and grow memory unbounded) on a live Icecast/HLS stream that never
+	// sends EOF; auto-detection (e.g. Content-Type: audio/mpeg) can't tell
+	// the two apart, so an un-overridden format always goes through the
+	// streaming ffmpeg path instead.
+	if format != FormatAuto && decodable(resolved) {
+		samples, err := decodeToPCM(audioReader, resolved)
+		audioReader.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("error decoding audio: %w", err)
+		}
+
+		track := &inProcessPlayback{samples: samples, speed: 1}
+		buf, err := track.rebuild(ctx, 0, 1)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error encoding audio: %w", err)
+		}
+		return buf, track, nil
+	}
+
+	oggReader, err := convertToOgg(ctx, audioReader, resolved)
 	if err != nil {
-		return "", fmt.Errorf("error converting stream: %w", err)
+		return nil, nil, fmt.Errorf("error converting stream: %w", err)
 	}
 
-	dir, err := saveOpusPackets(ctx, oggReader)
+	buf, err := saveOpusPackets(ctx, oggReader)
 	if err != nil {
-		return "", fmt.Errorf("error writing opus packets: %w", err)
+		return nil, nil, fmt.Errorf("error writing opus packets: %w", err)
 	}
 
-	return dir, nil
+	return buf, nil, nil
 }
 
+// resolveQueueSource resolves a queue item (a direct/HLS URL or a YouTube
+// link) into a ready-to-play ring buffer, returning any YouTube "now
+// playing" metadata rather than sending it: a prefetched item isn't current
+// yet, so the caller is responsible for announcing nowPlaying once it
+// actually becomes the track being played.
+func resolveQueueSource(ctx context.Context, item queueItem, onTitle func(string)) (*packetRingBuffer, *inProcessPlayback, *NowPlaying, error) {
+	sourceURL := item.URL
+	var nowPlaying *NowPlaying
+	if isYouTubeURL(sourceURL) {
+		info, err := resolveYouTube(ctx, sourceURL)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("error resolving youtube url: %w", err)
+		}
+		sourceURL = info.URL
+		nowPlaying = &NowPlaying{Title: info.Title, Duration: info.Duration}
+	}
+
+	buf, track, err := processAudioUrl(ctx, sourceURL, item.Format, onTitle)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return buf, track, nowPlaying, nil
+}
+
+// sendOpusPackets is the connection's single ticker-driven sender. onTrackEnd
+// is consulted both when the current buffer is genuinely exhausted (done and
+// fully drained) and when skipRequested is set; in either case it returns the
+// next buffer (and, for an in-process track, its *inProcessPlayback, so
+// "speed"/"seek" keep rebuilding whatever is actually playing) to swap to, or
+// ok=false if there's nothing queued. Swapping buffers is a pure pointer
+// assignment, so it never blocks the 20ms tick.
 func sendOpusPackets(
 	ctx context.Context,
-	opusDir *atomic.Pointer[string],
+	opusBuf *atomic.Pointer[packetRingBuffer],
+	currentTrack *atomic.Pointer[inProcessPlayback],
 	config *atomic.Pointer[Config],
 	pauseMs *atomic.Int64,
+	skipRequested *atomic.Bool,
+	onTrackEnd func() (*packetRingBuffer, *inProcessPlayback, bool),
 	writePacket func([]byte) error,
 ) {
 	start := time.Now()
 	ticks := 0
 	timer := time.After(0)
 	seq := 0
-	dir := opusDir.Load()
-	dirReady := false
+	buf := opusBuf.Load()
+	bufReady := false
+
+	swapTo := func(next *packetRingBuffer) {
+		buf = next
+		opusBuf.Store(next)
+		seq = 0
+		bufReady = false
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -214,54 +505,65 @@ func sendOpusPackets(
 			untilNext := max(time.Until(next), 0)
 			timer = time.After(untilNext)
 
-			newDir := opusDir.Load()
-			if newDir != dir {
-				seq = 0
-				dir = newDir
-				dirReady = false
+			newBuf := opusBuf.Load()
+			if newBuf != buf {
+				swapTo(newBuf)
 			}
 
-			if dir == nil {
+			if skipRequested.Swap(false) {
+				if queued, queuedTrack, ok := onTrackEnd(); ok {
+					swapTo(queued)
+					currentTrack.Store(queuedTrack)
+				}
+			}
+
+			if buf == nil {
 				continue
 			}
 
-			if !dirReady {
-				// wait until a couple packets are available
-				files, err := os.ReadDir(*dir)
-				if err != nil {
-					log.Printf("error reading directory: %v", err)
+			if !bufReady {
+				// de-jitter: wait until the buffer is filled ahead of the
+				// playback cursor by jitterBufferPackets before starting.
+				if buf.available()-seq < jitterBufferPackets {
 					continue
 				}
-				if len(files) < 5 {
+				bufReady = true
+			}
+
+			packet, ok := buf.read(seq)
+			if !ok {
+				if !buf.isDone() {
+					// the source just hasn't produced this packet yet
+					// (network/ffmpeg lagging playback); wait rather than
+					// treating it as the end of the track.
+					buf.recordUnderrun()
 					continue
 				}
 
-				dirReady = true
-			}
-
-			seq++
-			packet, err := os.ReadFile(path.Join(*dir, strconv.Itoa(seq)))
-			if err != nil {
-				if errors.Is(err, os.ErrNotExist) && seq > 1 {
-					// loop
-					seq = 1
-					packet, err = os.ReadFile(path.Join(*dir, strconv.Itoa(seq)))
+				// real end of track: hand off to whatever's queued, or fall
+				// back to looping this track forever like a single "start".
+				if queued, queuedTrack, ok := onTrackEnd(); ok {
+					swapTo(queued)
+					currentTrack.Store(queuedTrack)
+					continue
 				}
 
-				if err != nil {
-					log.Printf("error reading file: %v", err)
-					seq = 0
+				seq = 0
+				packet, ok = buf.read(seq)
+				if !ok {
+					buf.recordUnderrun()
 					continue
 				}
 			}
+			seq++
 
 			if cfg.PacketLoss > 0 && cfg.PacketLoss > rand.Float64() {
 				continue
 			}
 
-			err = writePacket(packet)
+			err := writePacket(packet)
 			if err != nil {
-				log.Printf("error reading file: %v", err)
+				log.Printf("error writing packet: %v", err)
 				seq = 0
 			}
 		}
@@ -276,7 +578,9 @@ func stream(w http.ResponseWriter, r *http.Request) {
 	}
 	defer conn.Close()
 
-	opusDir := &atomic.Pointer[string]{}
+	opusBuf := &atomic.Pointer[packetRingBuffer]{}
+	var writeMu sync.Mutex
+	var readCtx context.Context
 	var readCancel context.CancelFunc
 	defer func() {
 		if readCancel != nil {
@@ -289,8 +593,27 @@ func stream(w http.ResponseWriter, r *http.Request) {
 		PacketLoss: 0,
 	})
 	pauseMs := &atomic.Int64{}
+	skipRequested := &atomic.Bool{}
 
-	go sendOpusPackets(r.Context(), opusDir, config, pauseMs, func(b []byte) error {
+	onTitle := func(title string) {
+		if err := sendCommand(conn, &writeMu, "metadata", Metadata{Title: title}); err != nil {
+			log.Printf("error sending metadata: %v", err)
+		}
+	}
+
+	queue := &playlist{}
+	pl := newPlayer(r.Context(), queue, func(ctx context.Context, item queueItem) (*packetRingBuffer, *inProcessPlayback, *NowPlaying, error) {
+		return resolveQueueSource(ctx, item, onTitle)
+	}, func(np NowPlaying) {
+		if err := sendCommand(conn, &writeMu, "now_playing", np); err != nil {
+			log.Printf("error sending now_playing: %v", err)
+		}
+	})
+	currentTrack := &atomic.Pointer[inProcessPlayback]{}
+
+	go sendOpusPackets(r.Context(), opusBuf, currentTrack, config, pauseMs, skipRequested, pl.takeOnDeck, func(b []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
 		return conn.WriteMessage(websocket.BinaryMessage, b)
 	})
 
@@ -330,18 +653,86 @@ func stream(w http.ResponseWriter, r *http.Request) {
 				readCancel()
 				readCancel = nil
 			}
-			opusDir.Store(nil)
+			opusBuf.Store(nil)
+			pl.reset()
 
-			var readCtx context.Context
 			readCtx, readCancel = context.WithCancel(r.Context())
 
-			dir, err := processAudioUrl(readCtx, data.URL)
+			if data.MetadataURL != "" {
+				go pollMetadataURL(readCtx, data.MetadataURL, onTitle)
+			}
+
+			buf, track, nowPlaying, err := resolveQueueSource(readCtx, queueItem{URL: data.URL, Format: data.Format}, onTitle)
 			if err != nil {
 				log.Printf("error starting stream: %v", err)
 				continue
 			}
 
-			opusDir.Store(&dir)
+			opusBuf.Store(buf)
+			currentTrack.Store(track)
+			if nowPlaying != nil {
+				if err := sendCommand(conn, &writeMu, "now_playing", *nowPlaying); err != nil {
+					log.Printf("error sending now_playing: %v", err)
+				}
+			}
+
+		case "speed":
+			data := &SpeedData{}
+			if err := json.Unmarshal(cmd.Data, data); err != nil {
+				log.Printf("invalid speed command data: %v", err)
+				continue
+			}
+			track := currentTrack.Load()
+			if track == nil {
+				log.Println("speed command with no in-process track playing")
+				continue
+			}
+			newBuf, err := track.rebuild(readCtx, track.positionSeconds(), data.Speed)
+			if err != nil {
+				log.Printf("error applying speed: %v", err)
+				continue
+			}
+			opusBuf.Store(newBuf)
+
+		case "seek":
+			data := &SeekData{}
+			if err := json.Unmarshal(cmd.Data, data); err != nil {
+				log.Printf("invalid seek command data: %v", err)
+				continue
+			}
+			track := currentTrack.Load()
+			if track == nil {
+				log.Println("seek command with no in-process track playing")
+				continue
+			}
+			newBuf, err := track.rebuild(readCtx, data.Seconds, track.speed)
+			if err != nil {
+				log.Printf("error seeking: %v", err)
+				continue
+			}
+			opusBuf.Store(newBuf)
+
+		case "enqueue":
+			data := &queueItem{}
+			err = json.Unmarshal(cmd.Data, data)
+			if err != nil {
+				log.Printf("invalid enqueue command data: %v", err)
+				continue
+			}
+			if data.URL == "" {
+				log.Println("enqueue command missing url")
+				continue
+			}
+			queue.enqueue(*data)
+			pl.prefetchNext()
+
+		case "skip":
+			skipRequested.Store(true)
+
+		case "queue":
+			if err := sendCommand(conn, &writeMu, "queue", QueueList{Items: queue.snapshot()}); err != nil {
+				log.Printf("error sending queue: %v", err)
+			}
 
 		case "configure":
 			data := &Config{}
@@ -364,7 +755,9 @@ func stream(w http.ResponseWriter, r *http.Request) {
 				readCancel()
 				readCancel = nil
 			}
-			opusDir.Store(nil)
+			opusBuf.Store(nil)
+			currentTrack.Store(nil)
+			pl.reset()
 		}
 	}
 }
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// queueItem is one entry in a connection's playlist.
+type queueItem struct {
+	URL    string      `json:"url"`
+	Format AudioFormat `json:"format,omitempty"`
+}
+
+// QueueList is sent in response to a "queue" command.
+type QueueList struct {
+	Items []queueItem `json:"items"`
+}
+
+// playlist is a per-connection FIFO of queued sources. It's safe for
+// concurrent use by the command-handling goroutine and the player's
+// prefetch goroutines.
+type playlist struct {
+	mu    sync.Mutex
+	items []queueItem
+}
+
+func (p *playlist) enqueue(item queueItem) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.items = append(p.items, item)
+}
+
+// next pops and returns the next queued item, or ok=false if empty.
+func (p *playlist) next() (queueItem, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.items) == 0 {
+		return queueItem{}, false
+	}
+	item := p.items[0]
+	p.items = p.items[1:]
+	return item, true
+}
+
+func (p *playlist) clear() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.items = nil
+}
+
+func (p *playlist) snapshot() []queueItem {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make([]queueItem, len(p.items))
+	copy(out, p.items)
+	return out
+}
+
+// onDeckEntry is a queued item resolved ahead of time, plus whatever should
+// be announced to the client once it actually becomes the current track.
+// track is carried alongside buf (rather than discarded like the old
+// player did) so "speed"/"seek" can keep rebuilding whichever track is
+// actually playing across a gapless transition.
+type onDeckEntry struct {
+	buf        *packetRingBuffer
+	track      *inProcessPlayback
+	nowPlaying *NowPlaying
+}
+
+// player keeps one queued item pre-fetched ("on deck") so sendOpusPackets
+// can swap to it the instant the current track ends, without pausing the
+// tick to fetch and transcode a new source.
+type player struct {
+	ctx      context.Context
+	queue    *playlist
+	resolve  func(ctx context.Context, item queueItem) (*packetRingBuffer, *inProcessPlayback, *NowPlaying, error)
+	announce func(NowPlaying)
+
+	mu         sync.Mutex
+	onDeck     *onDeckEntry
+	generation int
+}
+
+func newPlayer(ctx context.Context, queue *playlist, resolve func(context.Context, queueItem) (*packetRingBuffer, *inProcessPlayback, *NowPlaying, error), announce func(NowPlaying)) *player {
+	return &player{ctx: ctx, queue: queue, resolve: resolve, announce: announce}
+}
+
+// prefetchNext resolves the next queued item in the background and stashes
+// the result as "on deck". It's a no-op if something is already on deck or
+// the queue is empty. Resolving doesn't announce anything to the client
+// yet: announce fires from takeOnDeck, when the item actually becomes
+// current, not here while it's merely being primed.
+func (pl *player) prefetchNext() {
+	pl.mu.Lock()
+	alreadyOnDeck := pl.onDeck != nil
+	generation := pl.generation
+	pl.mu.Unlock()
+	if alreadyOnDeck {
+		return
+	}
+
+	item, ok := pl.queue.next()
+	if !ok {
+		return
+	}
+
+	go func() {
+		buf, track, nowPlaying, err := pl.resolve(pl.ctx, item)
+		if err != nil {
+			log.Printf("error prefetching queued item %q: %v", item.URL, err)
+			pl.prefetchNext() // skip it and try the next queued item
+			return
+		}
+
+		pl.mu.Lock()
+		defer pl.mu.Unlock()
+		if generation != pl.generation {
+			// superseded by a reset() while we were resolving; discard
+			// instead of resurrecting an item the user already discarded.
+			return
+		}
+		pl.onDeck = &onDeckEntry{buf: buf, track: track, nowPlaying: nowPlaying}
+	}()
+}
+
+// takeOnDeck hands off the pre-fetched buffer and its *inProcessPlayback (if
+// any), announcing "now playing" metadata since it's now the item actually
+// becoming current, and kicks off prefetching whatever's queued after it.
+// takeOnDeck is called from sendOpusPackets' 20ms ticker, so announce runs
+// in its own goroutine rather than inline: announce does a blocking network
+// write, and a slow client socket must never stall the packet cadence.
+func (pl *player) takeOnDeck() (*packetRingBuffer, *inProcessPlayback, bool) {
+	pl.mu.Lock()
+	entry := pl.onDeck
+	pl.onDeck = nil
+	pl.mu.Unlock()
+
+	if entry == nil {
+		return nil, nil, false
+	}
+	if entry.nowPlaying != nil && pl.announce != nil {
+		go pl.announce(*entry.nowPlaying)
+	}
+	pl.prefetchNext()
+	return entry.buf, entry.track, true
+}
+
+// reset discards any pre-fetched buffer and pending queue, used when a
+// "start" command replaces whatever is currently playing. Bumping
+// generation also invalidates any prefetch goroutine already in flight, so
+// it drops its result instead of repopulating onDeck after the reset.
+func (pl *player) reset() {
+	pl.queue.clear()
+	pl.mu.Lock()
+	pl.onDeck = nil
+	pl.generation++
+	pl.mu.Unlock()
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"strings"
+)
+
+// youtubeInfo is the subset of yt-dlp's --dump-json output needed to start
+// playback and report "now playing" metadata to the client.
+type youtubeInfo struct {
+	Title    string  `json:"title"`
+	Duration float64 `json:"duration"`
+	URL      string  `json:"url"`
+}
+
+// isYouTubeURL reports whether rawURL looks like a youtube.com/youtu.be
+// video link, so the "start" handler can auto-detect it and route it
+// through yt-dlp instead of treating it as a direct audio URL.
+func isYouTubeURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Hostname()) {
+	case "youtube.com", "www.youtube.com", "m.youtube.com", "youtu.be":
+		return true
+	}
+	return false
+}
+
+// resolveYouTube shells out to yt-dlp to resolve videoURL to a direct,
+// best-available audio-only stream URL plus display metadata, the same way
+// convertToOgg shells out to ffmpeg.
+func resolveYouTube(ctx context.Context, videoURL string) (*youtubeInfo, error) {
+	cmd := exec.CommandContext(ctx, "yt-dlp",
+		"-f", "bestaudio",
+		"--no-playlist",
+		"--dump-json",
+		"--no-warnings",
+		videoURL,
+	)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("yt-dlp failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	info := &youtubeInfo{}
+	if err := json.Unmarshal(stdout.Bytes(), info); err != nil {
+		return nil, fmt.Errorf("error parsing yt-dlp output: %w", err)
+	}
+	if info.URL == "" {
+		return nil, fmt.Errorf("yt-dlp did not return a stream url")
+	}
+
+	return info, nil
+}